@@ -0,0 +1,156 @@
+package git
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// DefaultArchivePath is the URL path prefix archives are served under
+// when the `archive` block doesn't set one.
+const DefaultArchivePath = "/git/"
+
+// DefaultArchiveListen is the address the archive endpoint binds to
+// when the `archive` block doesn't set a listen address.
+const DefaultArchiveListen = "127.0.0.1:8086"
+
+// Archive holds the opt-in HTTP archive endpoint configuration for a
+// repo: GET <Path><name>.tar.gz?ref=<branch|tag|sha> returns a gzip'd tar
+// of the repo at that ref, so sidecars or downstream consumers can pull
+// the exact tree CoreDNS is serving without a git client of their own.
+type Archive struct {
+	Listen string
+	Path   string
+	MaxAge time.Duration
+}
+
+// archiveServer multiplexes archive requests for every repo that opted
+// in, one per listen address, keyed by the <name>.tar.gz route it was
+// registered under.
+type archiveServer struct {
+	mu      sync.RWMutex
+	repos   map[string]*Repo
+	addr    string
+	mux     *http.ServeMux
+	started bool
+}
+
+var (
+	archiveServersMu sync.Mutex
+	archiveServers   = map[string]*archiveServer{}
+)
+
+// archiveName derives the tarball name used in the route from repo.Path.
+func archiveName(repo *Repo) string { return filepath.Base(repo.Path) }
+
+// registerArchive adds repo to the archive server listening on
+// repo.Archive.Listen, starting that server the first time the address
+// is seen.
+func registerArchive(repo *Repo) error {
+	a := repo.Archive
+	route := a.Path + archiveName(repo) + ".tar.gz"
+
+	archiveServersMu.Lock()
+	as, ok := archiveServers[a.Listen]
+	if !ok {
+		as = &archiveServer{addr: a.Listen, repos: map[string]*Repo{}, mux: http.NewServeMux()}
+		archiveServers[a.Listen] = as
+	}
+	archiveServersMu.Unlock()
+
+	as.mu.Lock()
+	if _, exists := as.repos[route]; exists {
+		as.mu.Unlock()
+		return fmt.Errorf("archive route %v already registered on %v", route, a.Listen)
+	}
+	as.repos[route] = repo
+	as.mu.Unlock()
+
+	as.mux.HandleFunc(route, as.serveHTTP)
+
+	return as.start()
+}
+
+func (as *archiveServer) start() error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.started {
+		return nil
+	}
+	as.started = true
+
+	ln, err := net.Listen("tcp", as.addr)
+	if err != nil {
+		return fmt.Errorf("git: cannot start archive listener on %s: %s", as.addr, err)
+	}
+	go func() {
+		srv := &http.Server{Handler: as.mux}
+		if err := srv.Serve(ln); err != nil {
+			log.Errorf("archive listener on %s stopped: %s", as.addr, err)
+		}
+	}()
+	log.Infof("archive listener started on %s", as.addr)
+	return nil
+}
+
+func (as *archiveServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	as.mu.RLock()
+	repo := as.repos[r.URL.Path]
+	as.mu.RUnlock()
+
+	if repo == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ch, err := repo.activeCodehost()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// With no ?ref=, serve the tree currently being served rather than
+	// re-resolving repo.Branch: the bare cache only ever fetches branches
+	// under refs/remotes/origin (see refspec()), and repo.Branch alone
+	// doesn't account for {latest}-tag mode or a pinned commit checkout.
+	var hash plumbing.Hash
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		hash, err = ch.Resolve(ref)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot resolve ref %q: %s", ref, err), http.StatusNotFound)
+			return
+		}
+	} else {
+		if repo.lastCommit == "" {
+			http.Error(w, "repo has not been pulled yet", http.StatusServiceUnavailable)
+			return
+		}
+		hash = plumbing.NewHash(repo.lastCommit)
+	}
+
+	etag := `"` + hash.String() + `"`
+	if match := r.Header.Get("If-None-Match"); match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	maxAge := repo.Archive.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultCacheMaxAge
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+	if !repo.lastPull.IsZero() {
+		w.Header().Set("Last-Modified", repo.lastPull.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+
+	if err := ch.WriteArchive(hash, w); err != nil {
+		log.Errorf("archive request for %v failed: %s", repo.URL, err)
+	}
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// TestServeHTTPDefaultRefUsesLastCommit verifies that a request with no
+// ?ref= serves repo.lastCommit rather than re-resolving repo.Branch
+// directly, since a Subpath/CacheDir-backed repo's bare cache only ever
+// has the branch under refs/remotes/origin.
+func TestServeHTTPDefaultRefUsesLastCommit(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "git-archive-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	src, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := src.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "zone"), []byte("example.org.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("zone"); err != nil {
+		t.Fatal(err)
+	}
+	commitHash, err := wt.Commit("add zone", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.org", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cacheDir, err := ioutil.TempDir("", "git-archive-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	repo := &Repo{
+		URL:        srcDir,
+		Path:       filepath.Join(cacheDir, "export"),
+		Branch:     "master",
+		CacheDir:   cacheDir,
+		lastCommit: commitHash.String(),
+	}
+
+	as := &archiveServer{repos: map[string]*Repo{"/git/export.tar.gz": repo}}
+	req := httptest.NewRequest(http.MethodGet, "/git/export.tar.gz", nil)
+	rr := httptest.NewRecorder()
+	as.serveHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if etag := rr.Header().Get("ETag"); etag != `"`+commitHash.String()+`"` {
+		t.Errorf("expected ETag for lastCommit %s, got %s", commitHash, etag)
+	}
+}
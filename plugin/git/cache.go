@@ -0,0 +1,171 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultCacheDir is used to store shared bare repos when a repo block
+// selects a subdirectory via a #ref:subdir URL fragment without setting
+// cache_dir; startCacheGC and gcCache also fall back to it when given no dir.
+var DefaultCacheDir = filepath.Join(os.TempDir(), "coredns-git-cache")
+
+// DefaultCacheMaxAge is how long an unreferenced bare repo is kept on
+// disk before gcCache removes it.
+const DefaultCacheMaxAge = 24 * time.Hour
+
+// sharedCodehosts caches one codehost per (cacheDir, url) pair so that N
+// repo blocks referencing the same remote share a single bare clone and
+// a single fetch per interval, instead of each maintaining its own full
+// working copy.
+var (
+	sharedMu   sync.Mutex
+	shared     = map[string]*codehost{}
+	sharedUse  = map[string]time.Time{} // last time each bare dir was materialized
+	sharedRefs = map[string]int{}       // number of configured Repo blocks currently pointing at each bare dir
+)
+
+// bareDir returns the on-disk location of the bare repo cached for url
+// under cacheDir, deterministic so repeated server restarts reuse it.
+func bareDir(cacheDir, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+".git")
+}
+
+// registerSharedRef marks the bare repo cached for url under cacheDir as
+// referenced by a currently configured repo block, so gcCache never
+// evicts it no matter how long it's been since its last fetch. Repo.
+// Prepare calls this once per configured repo block at startup; refs
+// aren't decremented since repo blocks live for the process's lifetime.
+func registerSharedRef(cacheDir, url string) {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	dir := bareDir(cacheDir, url)
+
+	sharedMu.Lock()
+	sharedRefs[dir]++
+	sharedMu.Unlock()
+}
+
+// openSharedCodehost returns the cached bare-repo codehost for url under
+// cacheDir, cloning it the first time it's seen.
+func openSharedCodehost(cacheDir, url string, auth *Auth) (*codehost, error) {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	dir := bareDir(cacheDir, url)
+
+	sharedMu.Lock()
+	ch, ok := shared[dir]
+	if !ok {
+		ch = openCodehost(dir, auth)
+		shared[dir] = ch
+	}
+	sharedUse[dir] = time.Now()
+	sharedMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, err
+	}
+	if err := ch.EnsureCloned(url); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+var (
+	gcStartedMu sync.Mutex
+	gcStarted   = map[string]bool{}
+)
+
+// startCacheGC starts a background sweep of cacheDir, removing bare
+// repos unused for longer than maxAge, once per cacheDir for the life of
+// the process.
+func startCacheGC(cacheDir string, maxAge time.Duration) {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultCacheMaxAge
+	}
+
+	gcStartedMu.Lock()
+	defer gcStartedMu.Unlock()
+	if gcStarted[cacheDir] {
+		return
+	}
+	gcStarted[cacheDir] = true
+
+	go func() {
+		interval := maxAge / 2
+		if interval < time.Minute {
+			interval = time.Minute
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := gcCache(cacheDir, maxAge); err != nil {
+				log.Warningf("git: cache GC failed for %v: %s", cacheDir, err)
+			}
+		}
+	}()
+}
+
+// gcCache removes bare repos under cacheDir that aren't referenced by
+// any currently configured repo block (sharedRefs) and haven't backed a
+// pull for longer than maxAge. Checking sharedRefs first, rather than
+// just last-fetch time, keeps a repo block whose Interval outlives
+// maxAge from having its cache evicted out from under it. It's safe to
+// call concurrently with openSharedCodehost; a dir that's mid-clone
+// still has a fresh mtime and is skipped.
+func gcCache(cacheDir string, maxAge time.Duration) error {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultCacheMaxAge
+	}
+
+	entries, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	now := time.Now()
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cacheDir, e.Name())
+		if sharedRefs[dir] > 0 {
+			continue
+		}
+		lastUse, active := sharedUse[dir]
+		if active && now.Sub(lastUse) < maxAge {
+			continue
+		}
+		if !active && now.Sub(e.ModTime()) < maxAge {
+			continue
+		}
+		log.Infof("git: removing stale cache dir %v (unused for more than %s)", dir, maxAge)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warningf("git: failed to remove stale cache dir %v: %s", dir, err)
+			continue
+		}
+		delete(shared, dir)
+		delete(sharedUse, dir)
+	}
+	return nil
+}
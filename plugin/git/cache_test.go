@@ -0,0 +1,182 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestBareDir(t *testing.T) {
+	a := bareDir("/var/cache/git", "https://example.com/zones.git")
+	b := bareDir("/var/cache/git", "https://example.com/zones.git")
+	if a != b {
+		t.Errorf("bareDir should be deterministic for the same url, got %q and %q", a, b)
+	}
+
+	c := bareDir("/var/cache/git", "https://example.com/other.git")
+	if a == c {
+		t.Errorf("bareDir should differ for different urls, both got %q", a)
+	}
+}
+
+// TestOpenSharedCodehostResolvesBranch clones a local repo into a shared
+// bare cache and resolves its branch the same way pull()/clone() do, to
+// catch go-git's default (non-mirror) refspec landing branches under
+// refs/remotes/origin rather than refs/heads even for a bare repo.
+func TestOpenSharedCodehostResolvesBranch(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "git-cache-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	src, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := src.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "zone"), []byte("example.org.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("zone"); err != nil {
+		t.Fatal(err)
+	}
+	commitHash, err := wt.Commit("add zone", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.org", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := src.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := head.Name().Short()
+
+	cacheDir, err := ioutil.TempDir("", "git-cache-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	ch, err := openSharedCodehost(cacheDir, srcDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := ch.Resolve("origin/" + branch)
+	if err != nil {
+		t.Fatalf("Resolve(origin/%s) failed against a shared bare cache clone: %s", branch, err)
+	}
+	if hash != commitHash {
+		t.Errorf("resolved %s, want %s", hash, commitHash)
+	}
+}
+
+// TestGCCacheSkipsReferencedDir verifies that gcCache leaves a bare repo
+// alone as long as registerSharedRef has marked it as referenced by a
+// configured repo block, even if it's older than maxAge.
+func TestGCCacheSkipsReferencedDir(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "git-cache-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	dir := bareDir(cacheDir, "https://example.com/zones.git")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(dir, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	registerSharedRef(cacheDir, "https://example.com/zones.git")
+	defer func() {
+		sharedMu.Lock()
+		delete(sharedRefs, dir)
+		sharedMu.Unlock()
+	}()
+
+	if err := gcCache(cacheDir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected referenced cache dir to survive GC, got: %s", err)
+	}
+}
+
+// TestCodehostExportSubpath verifies that Export, given a subpath, writes
+// only that subtree to dest and strips the subpath prefix, which is what
+// lets a single #ref:subdir repo host several independent zone subsets.
+func TestCodehostExportSubpath(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "git-export-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	src, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := src.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "prod", "zone"), []byte("prod.example.org.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "README"), []byte("not zone data\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("."); err != nil {
+		t.Fatal(err)
+	}
+	commitHash, err := wt.Commit("add prod zone", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.org", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chDir, err := ioutil.TempDir("", "git-export-bare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(chDir)
+	ch := openCodehost(chDir, nil)
+	if err := ch.CloneBare(srcDir); err != nil {
+		t.Fatal(err)
+	}
+
+	dest, err := ioutil.TempDir("", "git-export-dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	if err := ch.Export(commitHash, dest, "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "zone")); err != nil {
+		t.Errorf("expected prod/zone to be exported as zone: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "README")); !os.IsNotExist(err) {
+		t.Errorf("expected README outside the subpath to be excluded, stat err = %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package git
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitHTTP "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// Auth holds the credentials used when talking to a remote repository,
+// configured via an `auth { ssh_key ... | token ... | basic user pass }`
+// Corefile block.
+type Auth struct {
+	SSHKey   string // path to an SSH private key
+	Token    string // OAuth/personal access token, sent as HTTP basic auth
+	User     string // basic auth username
+	Password string // basic auth password
+}
+
+// method returns the go-git transport.AuthMethod for a, or nil if a is
+// nil or empty, in which case go-git falls back to ambient credentials
+// (e.g. an ssh-agent or ~/.netrc).
+func (a *Auth) method() (transport.AuthMethod, error) {
+	if a == nil {
+		return nil, nil
+	}
+	switch {
+	case a.SSHKey != "":
+		return ssh.NewPublicKeysFromFile("git", a.SSHKey, "")
+	case a.Token != "":
+		return &gitHTTP.BasicAuth{Username: "x-access-token", Password: a.Token}, nil
+	case a.User != "":
+		return &gitHTTP.BasicAuth{Username: a.User, Password: a.Password}, nil
+	}
+	return nil, nil
+}
+
+// codehost is a cached, in-process handle to a single on-disk repository,
+// opened with go-git. Operations that clone, fetch into or check out the
+// repo hold mu exclusively; read-only ones (Resolve, Tags, Stat, Export,
+// WriteArchive, Push, OriginURL) share it and may run concurrently,
+// following the same one-repo, one-mutex pattern as
+// cmd/go/internal/modfetch/codehost.Repo.
+type codehost struct {
+	path string
+	auth *Auth
+
+	mu   sync.RWMutex
+	repo *git.Repository
+}
+
+var (
+	codehostsMu sync.Mutex
+	codehosts   = map[string]*codehost{} // keyed by Path
+)
+
+// openCodehost returns the cached codehost for path, creating one the
+// first time path is seen. One handle is kept per Path for the lifetime
+// of the process.
+func openCodehost(path string, auth *Auth) *codehost {
+	codehostsMu.Lock()
+	defer codehostsMu.Unlock()
+	if ch, ok := codehosts[path]; ok {
+		ch.auth = auth
+		return ch
+	}
+	ch := &codehost{path: path, auth: auth}
+	codehosts[path] = ch
+	return ch
+}
+
+// Clone clones url into ch.path at branch (or, if branch is empty, at
+// HEAD so the caller can check out a tag afterwards). depth limits the
+// fetch to that many commits, mirroring `git clone --depth`; 0 means
+// unlimited. submodules is one of the submodulesXxx constants.
+func (ch *codehost) Clone(url, branch string, depth int, submodules string) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	auth, err := ch.auth.method()
+	if err != nil {
+		return err
+	}
+
+	opts := &git.CloneOptions{URL: url, Auth: auth, Depth: depth}
+	if branch != "" {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(branch)
+		opts.SingleBranch = true
+	}
+	if submodules == submodulesRecursive || submodules == submodulesShallow {
+		// go-git has no shallow-submodule equivalent to `git clone
+		// --shallow-submodules`; fall back to a full recursive
+		// checkout and let top-level depth still apply.
+		opts.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+		if submodules == submodulesShallow {
+			log.Warning("git: submodules shallow is not supported by the go-git backend, cloning submodules recursively instead")
+		}
+	}
+
+	repo, err := git.PlainClone(ch.path, false, opts)
+	if err != nil {
+		return fmt.Errorf("git: clone of %s failed: %w", url, err)
+	}
+	ch.repo = repo
+	return nil
+}
+
+// CloneBare clones url as a bare repository into ch.path, used to seed
+// the shared bare repo kept per remote URL (see openSharedCodehost).
+func (ch *codehost) CloneBare(url string) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.cloneBareLocked(url)
+}
+
+func (ch *codehost) cloneBareLocked(url string) error {
+	auth, err := ch.auth.method()
+	if err != nil {
+		return err
+	}
+	repo, err := git.PlainClone(ch.path, true, &git.CloneOptions{URL: url, Auth: auth})
+	if err != nil {
+		return fmt.Errorf("git: bare clone of %s failed: %w", url, err)
+	}
+	ch.repo = repo
+	return nil
+}
+
+// EnsureCloned makes sure ch.path already holds a bare clone of url,
+// cloning it only if it doesn't. The existence check and the clone
+// happen under the same lock, so two callers racing to materialize a
+// shared cache entry for the same codehost can't both attempt a
+// CloneBare into the same directory.
+func (ch *codehost) EnsureCloned(url string) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.repo != nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(ch.path, "HEAD")); err == nil {
+		repo, err := git.PlainOpen(ch.path)
+		if err != nil {
+			return err
+		}
+		ch.repo = repo
+		return nil
+	}
+	return ch.cloneBareLocked(url)
+}
+
+// Export writes the tree at hash into dest as plain files, wiping dest
+// first. Unlike Checkout, this doesn't require or create a .git
+// directory at dest, so many Repo.Path directories can cheaply share one
+// bare repository's object store. If subpath is non-empty, only files
+// under that subtree are written, with paths made relative to it, so
+// dest ends up holding just that subset of the repo.
+func (ch *codehost) Export(hash plumbing.Hash, dest, subpath string) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	commit, err := ch.repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(subpath, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		name := f.Name
+		if prefix != "" {
+			if !strings.HasPrefix(name, prefix) {
+				return nil
+			}
+			name = strings.TrimPrefix(name, prefix)
+		}
+		full := filepath.Join(dest, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return err
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(full, []byte(content), 0644)
+	})
+}
+
+// WriteArchive writes the tree at hash to w as a gzip-compressed tar
+// archive, backing the optional HTTP archive endpoint.
+func (ch *codehost) WriteArchive(hash plumbing.Hash, w io.Writer) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	commit, err := ch.repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: f.Name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			return err
+		}
+		_, err = tw.Write([]byte(content))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Push force-pushes every branch fetched from origin (its
+// refs/remotes/origin/* refs, as branches of url) and every tag to url,
+// using auth instead of ch's own configured credentials since a mirror
+// destination commonly has different access than the origin.
+func (ch *codehost) Push(url string, auth *Auth) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	method, err := auth.method()
+	if err != nil {
+		return err
+	}
+
+	err = ch.repo.Push(&git.PushOptions{
+		RemoteURL: url,
+		Auth:      method,
+		Force:     true,
+		RefSpecs: []config.RefSpec{
+			"+refs/remotes/origin/*:refs/heads/*",
+			"+refs/tags/*:refs/tags/*",
+		},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("git: push to %s failed: %w", url, err)
+	}
+	return nil
+}
+
+// Open opens the already-cloned repository at ch.path.
+func (ch *codehost) Open() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.repo != nil {
+		return nil
+	}
+	repo, err := git.PlainOpen(ch.path)
+	if err != nil {
+		return err
+	}
+	ch.repo = repo
+	return nil
+}
+
+// Fetch fetches all refs (and, if tags is true, tags) for remote "origin".
+func (ch *codehost) Fetch(tags bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.repo == nil {
+		repo, err := git.PlainOpen(ch.path)
+		if err != nil {
+			return err
+		}
+		ch.repo = repo
+	}
+
+	auth, err := ch.auth.method()
+	if err != nil {
+		return err
+	}
+
+	opts := &git.FetchOptions{RemoteName: "origin", Auth: auth, Force: true}
+	if tags {
+		opts.Tags = git.AllTags
+	}
+	if err := ch.repo.Fetch(opts); err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("git: fetch failed: %w", err)
+	}
+	return nil
+}
+
+// Resolve resolves ref (branch, tag or short/long hash) to a commit hash.
+func (ch *codehost) Resolve(ref string) (plumbing.Hash, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	h, err := ch.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("git: cannot resolve %q: %w", ref, err)
+	}
+	return *h, nil
+}
+
+// Tags returns every tag name in the repository.
+func (ch *codehost) Tags() ([]string, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	iter, err := ch.repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tags = append(tags, ref.Name().Short())
+		return nil
+	})
+	return tags, err
+}
+
+// Checkout moves the worktree to hash.
+func (ch *codehost) Checkout(hash plumbing.Hash) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	wt, err := ch.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Checkout(&git.CheckoutOptions{Hash: hash})
+}
+
+// UpdateSubmodules brings every submodule in the worktree up to date
+// with what the parent commit records, recursing into nested submodules
+// when recursive is true.
+func (ch *codehost) UpdateSubmodules(recursive bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	wt, err := ch.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	subs, err := wt.Submodules()
+	if err != nil {
+		return err
+	}
+	return subs.Update(&git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: boolToRecursivity(recursive),
+	})
+}
+
+func boolToRecursivity(recursive bool) git.SubmoduleRescursivity {
+	if recursive {
+		return git.DefaultSubmoduleRecursionDepth
+	}
+	return git.NoRecurseSubmodules
+}
+
+// Stat returns the commit object for hash, equivalent to `git show <hash>`.
+func (ch *codehost) Stat(hash plumbing.Hash) (*plumbingCommit, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	c, err := ch.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &plumbingCommit{Hash: c.Hash.String(), Message: c.Message, When: c.Committer.When}, nil
+}
+
+// OriginURL returns the URL configured for remote "origin".
+func (ch *codehost) OriginURL() (string, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	cfg, err := ch.repo.Config()
+	if err != nil {
+		return "", err
+	}
+	remote, ok := cfg.Remotes["origin"]
+	if !ok || len(remote.URLs) == 0 {
+		return "", fmt.Errorf("git: no origin remote configured")
+	}
+	return remote.URLs[0], nil
+}
+
+// plumbingCommit is a minimal view of a go-git commit object, kept
+// independent of go-git's own type so callers don't need the import.
+type plumbingCommit struct {
+	Hash    string
+	Message string
+	When    time.Time
+}
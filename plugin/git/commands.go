@@ -1,51 +1,55 @@
 package git
 
-import (
-	"bytes"
-	"os"
-	"os/exec"
-	"sync"
-)
+import "strings"
 
-type gitCmd struct {
-	command string
-	args    []string
-	dir     string
-
-	sync.RWMutex
-}
-
-// Exec executes the command initiated in gitCmd.
-func (g *gitCmd) Exec(dir string) error {
-	g.Lock()
-	g.dir = dir
-	g.Unlock()
-	return runCmd(g.command, g.args, dir)
+// mapCloneArgs translates a subset of `git clone` flags (as configured via
+// the `args` directive) onto the go-git CloneOptions that have an
+// equivalent, returning the depth to pass and warning about anything it
+// can't map. This keeps existing Corefiles working after the move away
+// from shelling out to git.
+func mapCloneArgs(args []string) (depth int, singleBranch bool) {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--depth":
+			if i+1 < len(args) {
+				depth = atoiOrZero(args[i+1])
+				i++
+			}
+		case "--single-branch":
+			singleBranch = true
+		default:
+			if strings.HasPrefix(args[i], "--depth=") {
+				depth = atoiOrZero(strings.TrimPrefix(args[i], "--depth="))
+				continue
+			}
+			log.Warningf("git: clone arg %q has no go-git equivalent, ignoring", args[i])
+		}
+	}
+	return depth, singleBranch
 }
 
-// runCmd is a helper function to run commands.
-// It runs command with args from directory at dir.
-// The executed process outputs to os.Stderr
-func runCmd(command string, args []string, dir string) error {
-	cmd := exec.Command(command, args...)
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
-	cmd.Dir = dir
-	if err := cmd.Start(); err != nil {
-		return err
+// mapPullArgs translates a subset of `git pull` flags (as configured via
+// the `pull_args` directive). Today only --tags has a direct equivalent;
+// anything else is logged and ignored.
+func mapPullArgs(args []string) (tags bool) {
+	for _, a := range args {
+		switch a {
+		case "--tags":
+			tags = true
+		default:
+			log.Warningf("git: pull arg %q has no go-git equivalent, ignoring", a)
+		}
 	}
-	return cmd.Wait()
+	return tags
 }
 
-// runCmdOutput is a helper function to run commands and return output.
-// It runs command with args from directory at dir.
-// If successful, returns output and nil error
-func runCmdOutput(command string, args []string, dir string) (string, error) {
-	cmd := exec.Command(command, args...)
-	cmd.Dir = dir
-	var err error
-	if output, err := cmd.Output(); err == nil {
-		return string(bytes.TrimSpace(output)), nil
+func atoiOrZero(s string) int {
+	n := 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0
+		}
+		n = n*10 + int(r-'0')
 	}
-	return "", err
+	return n
 }
@@ -0,0 +1,41 @@
+package git
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Submodule handling modes for the `submodules` directive.
+const (
+	submodulesOff       = "off"
+	submodulesShallow   = "shallow"
+	submodulesRecursive = "recursive"
+)
+
+// splitGitFragment splits a Docker build-context style URL of the form
+// <repo>#<ref>:<subdir> into its repo, ref and subdir parts. Both ref and
+// subdir are optional; either half of the fragment may be omitted
+// (`#ref`, `#:subdir` or `#ref:subdir`). subdir is rejected if it tries
+// to escape the repo root.
+func splitGitFragment(url string) (repo, ref, subdir string, err error) {
+	hash := strings.Index(url, "#")
+	if hash < 0 {
+		return url, "", "", nil
+	}
+	repo = url[:hash]
+	fragment := url[hash+1:]
+
+	if colon := strings.Index(fragment, ":"); colon >= 0 {
+		ref, subdir = fragment[:colon], fragment[colon+1:]
+	} else {
+		ref = fragment
+	}
+
+	if subdir != "" {
+		if filepath.IsAbs(subdir) || strings.Contains(subdir, "..") {
+			return "", "", "", fmt.Errorf("invalid subdirectory %q in git URL fragment", subdir)
+		}
+	}
+	return repo, ref, subdir, nil
+}
@@ -0,0 +1,35 @@
+package git
+
+import "testing"
+
+func TestSplitGitFragment(t *testing.T) {
+	tests := []struct {
+		in                string
+		repo, ref, subdir string
+		shouldErr         bool
+	}{
+		{"https://example.com/zones.git", "https://example.com/zones.git", "", "", false},
+		{"https://example.com/zones.git#prod", "https://example.com/zones.git", "prod", "", false},
+		{"https://example.com/zones.git#prod:coredns/", "https://example.com/zones.git", "prod", "coredns/", false},
+		{"https://example.com/zones.git#:coredns/", "https://example.com/zones.git", "", "coredns/", false},
+		{"https://example.com/zones.git#prod:../escape", "", "", "", true},
+		{"https://example.com/zones.git#prod:/absolute", "", "", "", true},
+	}
+
+	for i, tc := range tests {
+		repo, ref, subdir, err := splitGitFragment(tc.in)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: unexpected error: %s", i, err)
+			continue
+		}
+		if repo != tc.repo || ref != tc.ref || subdir != tc.subdir {
+			t.Errorf("Test %v: got (%q, %q, %q), want (%q, %q, %q)", i, repo, ref, subdir, tc.repo, tc.ref, tc.subdir)
+		}
+	}
+}
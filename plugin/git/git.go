@@ -8,7 +8,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/coredns/caddy"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 const (
@@ -33,16 +33,25 @@ func (g Git) Repo(i int) *Repo {
 // Repo is the structure that holds required information
 // of a git repository.
 type Repo struct {
-	URL        string        // Repository URL
-	Path       string        // Directory to pull to
-	Branch     string        // Git branch
-	Interval   time.Duration // Interval between pulls
-	CloneArgs  []string      // Additonal cli args to pass to git clone
-	PullArgs   []string      // Additonal cli args to pass to git pull
-	pulled     bool          // true if there was a successful pull
-	lastPull   time.Time     // time of the last successful pull
-	lastCommit string        // hash for the most recent commit
-	latestTag  string        // latest tag name
+	URL         string        // Repository URL
+	Path        string        // Directory to pull to
+	Branch      string        // Git branch
+	Interval    time.Duration // Interval between pulls
+	CloneArgs   []string      // git clone flags, a subset mapped onto go-git options by mapCloneArgs
+	PullArgs    []string      // git pull flags, a subset mapped onto go-git options by mapPullArgs
+	Subpath     string        // subdirectory within the repo that holds the zone data, from a #ref:subdir URL fragment
+	Submodules  string        // off, shallow or recursive; defaults to off
+	CacheDir    string        // when set, Path is a cheap export from a shared bare repo cached under CacheDir, keyed by URL
+	CacheMaxAge time.Duration // how long an unused cache entry under CacheDir is kept around
+	Hook        *Hook         // webhook config, triggers an immediate Pull on a matching push event
+	HookListen  string        // address the webhook handler listens on
+	Archive     *Archive      // opt-in HTTP archive endpoint config
+	Mirrors     []*Mirror     // additional remotes to force-push to after each successful pull
+	Auth        *Auth         // credentials used for all go-git operations against URL
+	pulled      bool          // true if there was a successful pull
+	lastPull    time.Time     // time of the last successful pull
+	lastCommit  string        // hash for the most recent commit
+	latestTag   string        // latest tag name
 	sync.Mutex
 }
 
@@ -78,9 +87,63 @@ func (r *Repo) Pull() error {
 	if r.lastCommit == lastCommit {
 		log.Info("No new changes")
 	}
+
+	r.pushMirrors()
+
 	return nil
 }
 
+// codehost returns the cached go-git handle for this repo's Path.
+func (r *Repo) codehost() *codehost { return openCodehost(r.Path, r.Auth) }
+
+// cacheDirFor returns the cacheDir a shared bare-repo codehost should be
+// opened under for this repo, or "" if this repo instead gets a
+// dedicated working-tree clone at Path. Subpath implies a shared cache
+// under DefaultCacheDir even without an explicit cache_dir, since Path
+// only ever holds an Export of the selected subtree, never a git
+// working tree to check out in place.
+func (r *Repo) cacheDirFor() string {
+	if r.CacheDir != "" {
+		return r.CacheDir
+	}
+	if r.Subpath != "" {
+		return DefaultCacheDir
+	}
+	return ""
+}
+
+// activeCodehost returns the codehost backing this repo: a shared bare
+// repo cached under cacheDirFor() and keyed by URL, so that every repo
+// block pointing at the same remote shares one fetch and one object
+// store, or a dedicated clone at Path otherwise.
+func (r *Repo) activeCodehost() (*codehost, error) {
+	if dir := r.cacheDirFor(); dir != "" {
+		return openSharedCodehost(dir, r.URL, r.Auth)
+	}
+	return r.codehost(), nil
+}
+
+// refspec is the ref passed to Resolve for the configured Branch. Both a
+// dedicated clone and a CacheDir-backed bare repo fetch with go-git's
+// default (non-mirror) refspec, which always lands remote branches under
+// refs/remotes/origin rather than refs/heads, even for a bare repo.
+func (r *Repo) refspec() string {
+	return "origin/" + r.Branch
+}
+
+// materialize brings Path to hash: a plain checkout for a dedicated
+// clone, or a cheap export of the tree (filtered down to Subpath, if
+// set) from the shared object store when CacheDir or Subpath is set. In
+// the export case Path holds only the selected subtree, rooted directly
+// at Path, so whatever reads Path (e.g. the file or auto plugin) sees
+// just that zone data and not the rest of the repo.
+func (r *Repo) materialize(ch *codehost, hash plumbing.Hash) error {
+	if r.cacheDirFor() != "" {
+		return ch.Export(hash, r.Path, r.Subpath)
+	}
+	return ch.Checkout(hash)
+}
+
 // pull performs git pull, or git clone if repository does not exist.
 func (r *Repo) pull() error {
 
@@ -98,43 +161,79 @@ func (r *Repo) pull() error {
 		return nil
 	}
 
-	params := append([]string{"pull"}, append(r.PullArgs, "origin", r.Branch)...)
-	var err error
-	if err = r.gitCmd(params, r.Path); err == nil {
-		r.pulled = true
-		r.lastPull = time.Now()
-		log.Infof("pulled: %v", r.URL)
-		r.lastCommit, err = r.mostRecentCommit()
+	tags := mapPullArgs(r.PullArgs)
+	ch, err := r.activeCodehost()
+	if err != nil {
+		return err
+	}
+	if err := ch.Fetch(tags); err != nil {
+		return err
+	}
+	hash, err := ch.Resolve(r.refspec())
+	if err != nil {
+		return err
+	}
+	if err := r.materialize(ch, hash); err != nil {
+		return err
+	}
+	if r.cacheDirFor() == "" && r.Submodules != "" && r.Submodules != submodulesOff {
+		if err := ch.UpdateSubmodules(r.Submodules == submodulesRecursive); err != nil {
+			return err
+		}
 	}
-	return err
+
+	r.pulled = true
+	r.lastPull = time.Now()
+	log.Infof("pulled: %v", r.URL)
+	r.lastCommit = hash.String()
+	return nil
 }
 
 // clone performs git clone.
 func (r *Repo) clone() error {
-	params := append([]string{"clone", "-b", r.Branch}, append(r.CloneArgs, r.URL, r.Path)...)
+	depth, _ := mapCloneArgs(r.CloneArgs)
 
 	tagMode := r.Branch == latestTag
+	branch := r.Branch
 	if tagMode {
-		params = append([]string{"clone"}, append(r.CloneArgs, r.URL, r.Path)...)
+		branch = ""
 	}
 
-	var err error
-	if err = r.gitCmd(params, ""); err == nil {
-		r.pulled = true
-		r.lastPull = time.Now()
-		log.Infof("pulled: %v", r.URL)
-		r.lastCommit, err = r.mostRecentCommit()
-
-		// if latest tag config is set.
-		if tagMode {
-			if err := r.checkoutLatestTag(); err != nil {
-				log.Errorf("Error retrieving latest tag: %s", err)
-			}
+	ch, err := r.activeCodehost()
+	if err != nil {
+		return err
+	}
+	if r.cacheDirFor() == "" {
+		if err := ch.Clone(r.URL, branch, depth, r.Submodules); err != nil {
+			return err
+		}
+	}
+
+	r.pulled = true
+	r.lastPull = time.Now()
+	log.Infof("pulled: %v", r.URL)
+
+	ref := "HEAD"
+	if !tagMode {
+		ref = "origin/" + branch
+	}
+	hash, err := ch.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	if err := r.materialize(ch, hash); err != nil {
+		return err
+	}
+	r.lastCommit = hash.String()
+
+	if tagMode {
+		if err := r.checkoutLatestTag(); err != nil {
+			log.Errorf("Error retrieving latest tag: %s", err)
 			return err
 		}
 	}
 
-	return err
+	return nil
 }
 
 // checkoutLatestTag checks out the latest tag of the repository.
@@ -149,32 +248,47 @@ func (r *Repo) checkoutLatestTag() error {
 		return nil
 	}
 
-	params := []string{"checkout", "tags/" + tag}
-	if err = r.gitCmd(params, r.Path); err == nil {
-		r.latestTag = tag
-		r.lastCommit, err = r.mostRecentCommit()
-	} else {
+	ch, err := r.activeCodehost()
+	if err != nil {
+		return err
+	}
+	hash, err := ch.Resolve("refs/tags/" + tag)
+	if err != nil {
+		return err
+	}
+	if err := r.materialize(ch, hash); err != nil {
 		return err
 	}
+	r.latestTag = tag
+	r.lastCommit = hash.String()
 	return nil
 }
 
 // checkoutCommit checks out the specified commitHash.
 func (r *Repo) checkoutCommit(commitHash string) error {
-	var err error
-	params := []string{"checkout", commitHash}
-	if err = r.gitCmd(params, r.Path); err == nil {
-		log.Infof("commit %v checkout done", commitHash)
+	ch, err := r.activeCodehost()
+	if err != nil {
+		return err
+	}
+	hash := plumbing.NewHash(commitHash)
+	if err := r.materialize(ch, hash); err != nil {
+		return err
 	}
-	return err
+	log.Infof("commit %v checkout done", commitHash)
+	return nil
 }
 
-// gitCmd performs a git command.
-func (r *Repo) gitCmd(params []string, dir string) error { return runCmd("git", params, dir) }
-
 // Prepare prepares for a git pull
 // and validates the configured directory
 func (r *Repo) Prepare() error {
+	// CacheDir/Subpath repos materialize via Export, which wipes and
+	// rewrites Path on every pull and never leaves a .git directory
+	// there, so the dedicated-clone checks below don't apply: Path
+	// having leftover data from an earlier run isn't a conflict.
+	if r.cacheDirFor() != "" {
+		return r.prepareExport()
+	}
+
 	// check if directory exists or is empty
 	// if not, create directory
 	fs, err := ioutil.ReadDir(r.Path)
@@ -208,40 +322,58 @@ func (r *Repo) Prepare() error {
 	return fmt.Errorf("cannot git clone into %v, directory not empty", r.Path)
 }
 
-// getMostRecentCommit gets the hash of the most recent commit to the
-// repository. Useful for checking if changes occur.
-func (r *Repo) mostRecentCommit() (string, error) {
-	command := "git" + ` --no-pager log -n 1 --pretty=format:"%H"`
-	c, args, err := caddy.SplitCommandAndArgs(command)
-	if err != nil {
-		return "", err
-	}
-	return runCmdOutput(c, args, r.Path)
+// prepareExport prepares Path for a repo materialized via Export: it
+// only needs to exist, since clone()/pull() wipe and repopulate it every
+// time regardless of what's left over from an earlier process run. The
+// shared bare repo that's actually reused across restarts lives under
+// cacheDirFor(), not Path, so it's registered here to keep cache GC from
+// evicting it out from under this repo block.
+func (r *Repo) prepareExport() error {
+	registerSharedRef(r.cacheDirFor(), r.URL)
+	return os.MkdirAll(r.Path, os.FileMode(0755))
 }
 
-// fetchLatestTag retrieves the most recent tag in the repository.
+// fetchLatestTag retrieves the most recent tag in the repository, i.e.
+// the tag whose target commit has the newest commit time.
 func (r *Repo) fetchLatestTag() (string, error) {
-	// fetch updates to get latest tag
-	params := []string{"fetch", "origin", "--tags"}
-	err := r.gitCmd(params, r.Path)
+	ch, err := r.activeCodehost()
 	if err != nil {
 		return "", err
 	}
-	// retrieve latest tag
-	command := "git" + ` describe origin --abbrev=0 --tags`
-	c, args, err := caddy.SplitCommandAndArgs(command)
+	if err := ch.Fetch(true); err != nil {
+		return "", err
+	}
+	tags, err := ch.Tags()
 	if err != nil {
 		return "", err
 	}
-	return runCmdOutput(c, args, r.Path)
+
+	var latest string
+	var latestTime time.Time
+	for _, tag := range tags {
+		hash, err := ch.Resolve("refs/tags/" + tag)
+		if err != nil {
+			continue
+		}
+		commit, err := ch.Stat(hash)
+		if err != nil {
+			continue
+		}
+		if commit.When.After(latestTime) {
+			latest = tag
+			latestTime = commit.When
+		}
+	}
+	return latest, nil
 }
 
 // originURL retrieves remote origin url for the git repository at path
 func (r *Repo) originURL() (string, error) {
-	_, err := os.Stat(r.Path)
-	if err != nil {
+	if _, err := os.Stat(r.Path); err != nil {
+		return "", err
+	}
+	if err := r.codehost().Open(); err != nil {
 		return "", err
 	}
-	args := []string{"config", "--get", "remote.origin.url"}
-	return runCmdOutput("git", args, r.Path)
+	return r.codehost().OriginURL()
 }
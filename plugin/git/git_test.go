@@ -0,0 +1,29 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPrepareExportReusesPopulatedPath verifies that Prepare doesn't treat
+// a Path left over from a previous pull as a conflict when CacheDir or
+// Subpath is set, since those repos materialize via Export rather than a
+// dedicated clone and never leave a .git directory at Path.
+func TestPrepareExportReusesPopulatedPath(t *testing.T) {
+	path, err := ioutil.TempDir("", "git-prepare-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	if err := ioutil.WriteFile(filepath.Join(path, "zone"), []byte("example.org.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Repo{URL: "https://example.com/zones.git", Path: path, Subpath: "prod"}
+	if err := r.Prepare(); err != nil {
+		t.Fatalf("Prepare failed on a Path populated by an earlier Export: %s", err)
+	}
+}
@@ -0,0 +1,80 @@
+package git
+
+import (
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// mirrorMaxRetries bounds the exponential backoff used for mirror
+// pushes. This is independent of numRetries, which only governs the
+// pull loop.
+const mirrorMaxRetries = 5
+
+// mirrorMaxBackoff caps the delay between push retries.
+const mirrorMaxBackoff = 5 * time.Minute
+
+var (
+	mirrorPushSuccessCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "git",
+		Name:      "mirror_push_success_total",
+		Help:      "Counter of successful pushes to a mirror remote.",
+	}, []string{"remote"})
+
+	mirrorPushFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "git",
+		Name:      "mirror_push_failure_total",
+		Help:      "Counter of failed pushes to a mirror remote, including retries.",
+	}, []string{"remote"})
+)
+
+// Mirror is an additional remote that a successful Repo.Pull() force-
+// pushes the freshly-pulled refs to, turning this plugin into a low-
+// latency fan-out point for zone-data repos (e.g. a Gerrit or Gitea
+// primary mirroring to a read-only GitHub or offsite copy).
+type Mirror struct {
+	URL  string
+	Auth *Auth
+}
+
+// pushMirrors asynchronously pushes the repo's current refs to every
+// configured mirror. Each destination is retried independently with
+// exponential backoff, so one mirror's failures and backoff waits don't
+// hold up the others or Pull itself. A push in progress holds the
+// codehost's read lock, so the next pull's fetch waits for it to finish.
+func (r *Repo) pushMirrors() {
+	if len(r.Mirrors) == 0 {
+		return
+	}
+	ch, err := r.activeCodehost()
+	if err != nil {
+		log.Errorf("git: cannot push mirrors for %v: %s", r.URL, err)
+		return
+	}
+	for _, m := range r.Mirrors {
+		go pushMirrorWithBackoff(ch, m)
+	}
+}
+
+func pushMirrorWithBackoff(ch *codehost, m *Mirror) {
+	backoff := time.Second
+	var err error
+	for attempt := 0; attempt < mirrorMaxRetries; attempt++ {
+		if err = ch.Push(m.URL, m.Auth); err == nil {
+			mirrorPushSuccessCount.WithLabelValues(m.URL).Inc()
+			return
+		}
+		mirrorPushFailureCount.WithLabelValues(m.URL).Inc()
+		log.Warningf("git: push to mirror %v failed (attempt %d/%d): %s", m.URL, attempt+1, mirrorMaxRetries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > mirrorMaxBackoff {
+			backoff = mirrorMaxBackoff
+		}
+	}
+	log.Errorf("git: giving up pushing to mirror %v after %d attempts: %s", m.URL, mirrorMaxRetries, err)
+}
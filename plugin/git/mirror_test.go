@@ -0,0 +1,46 @@
+package git
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParseMirror(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		url       string
+		hasAuth   bool
+	}{
+		{`mirror https://example.com/mirror.git`, false, "https://example.com/mirror.git", false},
+		{`mirror https://example.com/mirror.git {
+			auth {
+				token abc123
+			}
+		}`, false, "https://example.com/mirror.git", true},
+		{`mirror`, true, "", false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		c.Next() // consume the directive name so NextArg lines up like during parse()
+		m, err := parseMirror(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: unexpected error: %s", i, err)
+			continue
+		}
+		if m.URL != test.url {
+			t.Errorf("Test %v: expected URL %q, got %q", i, test.url, m.URL)
+		}
+		if (m.Auth != nil) != test.hasAuth {
+			t.Errorf("Test %v: expected hasAuth %v, got %v", i, test.hasAuth, m.Auth != nil)
+		}
+	}
+}
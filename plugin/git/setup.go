@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -18,6 +19,10 @@ const (
 	// DefaultInterval is the minimum interval to delay before
 	// requesting another git pull
 	DefaultInterval time.Duration = time.Hour
+
+	// DefaultHookListen is the address the webhook handler binds to
+	// when hook_listen is not set.
+	DefaultHookListen = "127.0.0.1:8085"
 )
 
 func init() { plugin.Register("git", setup) }
@@ -34,6 +39,22 @@ func setup(c *caddy.Controller) error {
 	for i := range git {
 		repo := git.Repo(i)
 
+		if repo.Hook != nil {
+			if err := registerHook(repo.HookListen, repo); err != nil {
+				return plugin.Error("git", err)
+			}
+		}
+
+		if repo.CacheDir != "" {
+			startCacheGC(repo.CacheDir, repo.CacheMaxAge)
+		}
+
+		if repo.Archive != nil {
+			if err := registerArchive(repo); err != nil {
+				return plugin.Error("git", err)
+			}
+		}
+
 		startupFuncs = append(startupFuncs, func() error {
 
 			// Start service routine in background
@@ -109,6 +130,76 @@ func parse(c *caddy.Controller) (Git, error) {
 				repo.CloneArgs = c.RemainingArgs()
 			case "pull_args":
 				repo.PullArgs = c.RemainingArgs()
+			case "cache_dir":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				repo.CacheDir = clonePath(c.Val())
+			case "max_age":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				t, _ := strconv.Atoi(c.Val())
+				if t > 0 {
+					repo.CacheMaxAge = time.Duration(t) * time.Second
+				}
+			case "submodules":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				switch c.Val() {
+				case submodulesOff, submodulesShallow, submodulesRecursive:
+					repo.Submodules = c.Val()
+				default:
+					return nil, plugin.Error("git", fmt.Errorf("invalid submodules mode %q", c.Val()))
+				}
+			case "auth":
+				auth, err := parseAuth(c)
+				if err != nil {
+					return nil, plugin.Error("git", err)
+				}
+				repo.Auth = auth
+			case "archive":
+				archive, err := parseArchive(c)
+				if err != nil {
+					return nil, plugin.Error("git", err)
+				}
+				repo.Archive = archive
+			case "mirror":
+				mirror, err := parseMirror(c)
+				if err != nil {
+					return nil, plugin.Error("git", err)
+				}
+				repo.Mirrors = append(repo.Mirrors, mirror)
+			case "hook_url":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				if repo.Hook == nil {
+					repo.Hook = &Hook{}
+				}
+				repo.Hook.Path = c.Val()
+			case "hook_type":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				if repo.Hook == nil {
+					repo.Hook = &Hook{}
+				}
+				repo.Hook.Type = c.Val()
+			case "hook_secret":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				if repo.Hook == nil {
+					repo.Hook = &Hook{}
+				}
+				repo.Hook.Secret = c.Val()
+			case "hook_listen":
+				if !c.NextArg() {
+					return nil, plugin.Error("git", c.ArgErr())
+				}
+				repo.HookListen = c.Val()
 			default:
 				return nil, plugin.Error("git", c.ArgErr())
 			}
@@ -119,10 +210,33 @@ func parse(c *caddy.Controller) (Git, error) {
 			return nil, plugin.Error("git", fmt.Errorf("no URL set"))
 		}
 
+		branchSet := repo.Branch != "master"
+		url, ref, subdir, err := splitGitFragment(repo.URL)
+		if err != nil {
+			return nil, plugin.Error("git", err)
+		}
+		repo.URL = url
+		repo.Subpath = subdir
+		if ref != "" && !branchSet {
+			repo.Branch = ref
+		}
+
 		if repo.Path == "" {
 			return nil, plugin.Error("git", fmt.Errorf("no path set"))
 		}
 
+		if repo.Hook != nil {
+			if repo.Hook.Path == "" {
+				return nil, plugin.Error("git", fmt.Errorf("hook_url required when a hook is configured"))
+			}
+			if repo.Hook.Secret == "" {
+				return nil, plugin.Error("git", fmt.Errorf("hook_secret required when a hook is configured, refusing to accept unauthenticated push events"))
+			}
+			if repo.HookListen == "" {
+				repo.HookListen = DefaultHookListen
+			}
+		}
+
 		// prepare repo for use
 		if err := repo.Prepare(); err != nil {
 			return nil, plugin.Error("git", err)
@@ -133,3 +247,113 @@ func parse(c *caddy.Controller) (Git, error) {
 
 	return git, nil
 }
+
+// parseAuth parses an `auth { ssh_key <path> | token <token> | basic <user> <password> }`
+// block. Only one of the three forms may be used per repo.
+func parseAuth(c *caddy.Controller) (*Auth, error) {
+	auth := &Auth{}
+	if !c.NextArg() || c.Val() != "{" {
+		return nil, c.ArgErr()
+	}
+	for c.Next() && c.Val() != "}" {
+		switch c.Val() {
+		case "ssh_key":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			auth.SSHKey = c.Val()
+		case "token":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			auth.Token = c.Val()
+		case "basic":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return nil, c.ArgErr()
+			}
+			auth.User, auth.Password = args[0], args[1]
+		default:
+			return nil, c.ArgErr()
+		}
+	}
+	forms := 0
+	for _, set := range []bool{auth.SSHKey != "", auth.Token != "", auth.User != ""} {
+		if set {
+			forms++
+		}
+	}
+	if forms > 1 {
+		return nil, c.Err("only one of ssh_key, token or basic may be set in an auth block")
+	}
+	return auth, nil
+}
+
+// parseMirror parses a `mirror <remote-url> { auth { ... } }` directive.
+// The auth sub-block is optional and only applies to pushes to this
+// particular mirror.
+func parseMirror(c *caddy.Controller) (*Mirror, error) {
+	if !c.NextArg() {
+		return nil, c.ArgErr()
+	}
+	mirror := &Mirror{URL: c.Val()}
+	if !c.NextArg() {
+		return mirror, nil
+	}
+	if c.Val() != "{" {
+		return nil, c.ArgErr()
+	}
+	for c.Next() && c.Val() != "}" {
+		switch c.Val() {
+		case "auth":
+			auth, err := parseAuth(c)
+			if err != nil {
+				return nil, err
+			}
+			mirror.Auth = auth
+		default:
+			return nil, c.ArgErr()
+		}
+	}
+	return mirror, nil
+}
+
+// parseArchive parses an `archive { listen <addr>, path <prefix>, max_age <seconds> }` block.
+func parseArchive(c *caddy.Controller) (*Archive, error) {
+	archive := &Archive{Listen: DefaultArchiveListen, Path: DefaultArchivePath}
+	if !c.NextArg() {
+		return archive, nil
+	}
+	if c.Val() != "{" {
+		return nil, c.ArgErr()
+	}
+	for c.Next() && c.Val() != "}" {
+		switch c.Val() {
+		case "listen":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			archive.Listen = c.Val()
+		case "path":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			p := c.Val()
+			if !strings.HasSuffix(p, "/") {
+				p += "/"
+			}
+			archive.Path = p
+		case "max_age":
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			t, _ := strconv.Atoi(c.Val())
+			if t > 0 {
+				archive.MaxAge = time.Duration(t) * time.Second
+			}
+		default:
+			return nil, c.ArgErr()
+		}
+	}
+	return archive, nil
+}
@@ -49,6 +49,17 @@ func TestGitParse(t *testing.T) {
 	}
 }
 
+func TestGitParseHookRequiresSecret(t *testing.T) {
+	input := `git https://example.com/user/repo.git {
+		path /tmp/git2
+		hook_url /hook
+	}`
+	c := caddy.NewTestController("dns", input)
+	if _, err := parse(c); err == nil {
+		t.Error("expected an error for a hook configured without hook_secret")
+	}
+}
+
 func reposEqual(expected, repo *Repo) bool {
 	if expected == nil {
 		return repo == nil
@@ -0,0 +1,108 @@
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyGitHub(t *testing.T) {
+	secret := "s3cr3t"
+	body := []byte(`{"ref":"refs/heads/master"}`)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", nil)
+	req.Header.Set("X-Hub-Signature-256", sig)
+
+	ref, err := verifyGitHub(secret, req, body)
+	if err != nil {
+		t.Fatalf("expected valid signature, got error: %s", err)
+	}
+	if ref != "refs/heads/master" {
+		t.Errorf("expected ref refs/heads/master, got %s", ref)
+	}
+
+	req.Header.Set("X-Hub-Signature-256", "sha256=deadbeef")
+	if _, err := verifyGitHub(secret, req, body); err == nil {
+		t.Error("expected error for mismatched signature")
+	}
+}
+
+func TestVerifyGitLab(t *testing.T) {
+	secret := "s3cr3t"
+	body := []byte(`{"ref":"refs/heads/master"}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", nil)
+	req.Header.Set("X-Gitlab-Token", secret)
+
+	ref, err := verifyGitLab(secret, req, body)
+	if err != nil {
+		t.Fatalf("expected valid token, got error: %s", err)
+	}
+	if ref != "refs/heads/master" {
+		t.Errorf("expected ref refs/heads/master, got %s", ref)
+	}
+
+	req.Header.Set("X-Gitlab-Token", "wrong")
+	if _, err := verifyGitLab(secret, req, body); err == nil {
+		t.Error("expected error for mismatched token")
+	}
+}
+
+func TestVerifyBitbucket(t *testing.T) {
+	secret := "s3cr3t"
+	body := []byte(`{"push":{"changes":[{"new":{"name":"master"}}]}}`)
+
+	// Bitbucket Server: signed like GitHub.
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", nil)
+	req.Header.Set("X-Hub-Signature-256", sig)
+	ref, err := verifyBitbucket(secret, req, body)
+	if err != nil {
+		t.Fatalf("expected valid signature, got error: %s", err)
+	}
+	if ref != "master" {
+		t.Errorf("expected ref master, got %s", ref)
+	}
+
+	// Bitbucket Cloud: no signature header, secret carried in the URL.
+	req = httptest.NewRequest(http.MethodPost, "/hook?secret="+secret, nil)
+	ref, err = verifyBitbucket(secret, req, body)
+	if err != nil {
+		t.Fatalf("expected valid query secret, got error: %s", err)
+	}
+	if ref != "master" {
+		t.Errorf("expected ref master, got %s", ref)
+	}
+
+	// Neither a signature nor a matching query secret: fail closed.
+	req = httptest.NewRequest(http.MethodPost, "/hook", nil)
+	if _, err := verifyBitbucket(secret, req, body); err == nil {
+		t.Error("expected error when no signature or query secret is present")
+	}
+}
+
+func TestBranchMatches(t *testing.T) {
+	tests := []struct {
+		configured, ref string
+		want            bool
+	}{
+		{"master", "refs/heads/master", true},
+		{"master", "refs/heads/dev", false},
+		{"dev", "refs/heads/dev", true},
+	}
+	for _, tc := range tests {
+		if got := branchMatches(tc.configured, tc.ref); got != tc.want {
+			t.Errorf("branchMatches(%q, %q) = %v, want %v", tc.configured, tc.ref, got, tc.want)
+		}
+	}
+}